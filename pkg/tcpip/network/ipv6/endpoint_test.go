@@ -0,0 +1,58 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// fakeLinkEndpoint is a minimal stack.LinkEndpoint that records the last
+// packet handed to WritePacket, so tests can drive self-initiated sends
+// (sendNeighborSolicit, writeMessage, writeV2Report, raAdvertiser.writeRA) that
+// go straight through e.linkEP, without a real network stack underneath.
+type fakeLinkEndpoint struct {
+	linkAddr tcpip.LinkAddress
+
+	writePacketCalled bool
+	lastRoute         *stack.Route
+	lastPkt           stack.PacketBuffer
+}
+
+func (f *fakeLinkEndpoint) MTU() uint32 { return 1500 }
+
+func (f *fakeLinkEndpoint) Capabilities() stack.LinkEndpointCapabilities { return 0 }
+
+func (f *fakeLinkEndpoint) MaxHeaderLength() uint16 { return 0 }
+
+func (f *fakeLinkEndpoint) LinkAddress() tcpip.LinkAddress { return f.linkAddr }
+
+func (f *fakeLinkEndpoint) WritePacket(r *stack.Route, _ *stack.GSO, _ tcpip.NetworkProtocolNumber, pkt stack.PacketBuffer) *tcpip.Error {
+	f.writePacketCalled = true
+	f.lastRoute = r
+	f.lastPkt = pkt
+	return nil
+}
+
+func (f *fakeLinkEndpoint) WriteRawPacket(buffer.VectorisedView) *tcpip.Error { return nil }
+
+func (f *fakeLinkEndpoint) Attach(stack.NetworkDispatcher) {}
+
+func (f *fakeLinkEndpoint) IsAttached() bool { return true }
+
+func (f *fakeLinkEndpoint) Wait() {}
+
+var _ stack.LinkEndpoint = (*fakeLinkEndpoint)(nil)
@@ -216,6 +216,7 @@ func (e *endpoint) handleICMP(r *stack.Route, netHeader buffer.View, pkt stack.P
 			return
 		} else {
 			e.nud.HandleProbe(r.RemoteAddress, r.LocalAddress, header.IPv6ProtocolNumber, sourceLinkAddr)
+			e.neighbors().handleProbe(r.RemoteAddress, sourceLinkAddr)
 		}
 
 		// ICMPv6 Neighbor Solicit messages are always sent to
@@ -349,6 +350,7 @@ func (e *endpoint) handleICMP(r *stack.Route, netHeader buffer.View, pkt stack.P
 					Override:  na.OverrideFlag(),
 					IsRouter:  na.RouterFlag(),
 				})
+				e.neighbors().handleUpperLayerConfirmation(targetAddr)
 			}
 		}
 
@@ -451,6 +453,7 @@ func (e *endpoint) handleICMP(r *stack.Route, netHeader buffer.View, pkt stack.P
 				// A RS with a specified source IP address modifies the NUD state
 				// machine in the same way a reachability probe would.
 				e.nud.HandleProbe(sourceAddr, r.LocalAddress, header.IPv6ProtocolNumber, opt.EthernetAddress())
+				e.neighbors().handleProbe(sourceAddr, opt.EthernetAddress())
 			}
 		}
 
@@ -459,6 +462,10 @@ func (e *endpoint) handleICMP(r *stack.Route, netHeader buffer.View, pkt stack.P
 		// as RFC 4861 section 6.1.1 is concerned.
 		//
 
+		// Send a solicited Router Advertisement within MAX_RA_DELAY_TIME, as
+		// per RFC 4861 section 6.2.6, if this NIC is configured to advertise.
+		e.raAdvertiser().handleRS(r)
+
 	case header.ICMPv6RouterAdvert:
 		received.RouterAdvert.Increment()
 
@@ -515,32 +522,61 @@ func (e *endpoint) handleICMP(r *stack.Route, netHeader buffer.View, pkt stack.P
 			switch opt := opt.(type) {
 			case header.NDPSourceLinkLayerAddressOption:
 				e.nud.HandleProbe(routerAddr, r.LocalAddress, header.IPv6ProtocolNumber, opt.EthernetAddress())
+				e.neighbors().handleProbe(routerAddr, opt.EthernetAddress())
 			}
 		}
 
+	case header.ICMPv6MulticastListenerQuery:
+		received.MulticastListenerQuery.Increment()
+		// As per RFC 2710 section 5, MLD messages are only valid when sent
+		// with an IPv6 Hop Limit of 1 and without a fragmentation header.
+		if hasFragmentHeader || iph.HopLimit() != 1 || len(v) < header.ICMPv6HeaderSize+header.MLDMinimumSize {
+			received.Invalid.Increment()
+			return
+		}
+		isV2Query := len(v) >= header.ICMPv6HeaderSize+header.MLDMinimumSize+mldv2QueryExtraSize
+		e.mldState().handleQuery(iph, header.MLD(h.MessageBody()), isV2Query)
+
+	case header.ICMPv6MulticastListenerReport:
+		received.MulticastListenerReport.Increment()
+		if iph.HopLimit() != 1 || len(v) < header.ICMPv6HeaderSize+header.MLDMinimumSize {
+			received.Invalid.Increment()
+			return
+		}
+		e.mldState().handleReport(header.MLD(h.MessageBody()))
+
+	case header.ICMPv6MulticastListenerV2Report:
+		received.MulticastListenerV2Report.Increment()
+		// The body is Reserved (2), Nr of Records (2), then the first
+		// Multicast Address Record, whose Multicast Address sits at the same
+		// offset within the record as in an MLDv1 body.
+		if iph.HopLimit() != 1 || len(v) < header.ICMPv6HeaderSize+4+mldv2ReportRecordSize {
+			received.Invalid.Increment()
+			return
+		}
+		e.mldState().handleReport(header.MLD(h.MessageBody()[4:]))
+
+	case header.ICMPv6MulticastListenerDone:
+		received.MulticastListenerDone.Increment()
+		if iph.HopLimit() != 1 || len(v) < header.ICMPv6HeaderSize+header.MLDMinimumSize {
+			received.Invalid.Increment()
+			return
+		}
+		e.mldState().handleDone(header.MLD(h.MessageBody()))
+
 	case header.ICMPv6RedirectMsg:
-		// TODO(gvisor.dev/issue/2285): Call `e.nud.HandleProbe` after validating
-		// this redirect message, as per RFC 4871 section 7.3.3:
-		//
-		//    "A Neighbor Cache entry enters the STALE state when created as a
-		//    result of receiving packets other than solicited Neighbor
-		//    Advertisements (i.e., Router Solicitations, Router Advertisements,
-		//    Redirects, and Neighbor Solicitations).  These packets contain the
-		//    link-layer address of either the sender or, in the case of Redirect,
-		//    the redirection target.  However, receipt of these link-layer
-		//    addresses does not confirm reachability of the forward-direction path
-		//    to that node.  Placing a newly created Neighbor Cache entry for which
-		//    the link-layer address is known in the STALE state provides assurance
-		//    that path failures are detected quickly. In addition, should a cached
-		//    link-layer address be modified due to receiving one of the above
-		//    messages, the state SHOULD also be set to STALE to provide prompt
-		//    verification that the path to the new link-layer address is working."
 		received.RedirectMsg.Increment()
 		if !isNDPValid() {
 			received.Invalid.Increment()
 			return
 		}
 
+		// Validate and apply the redirect as per RFC 4861 section 8.3; see
+		// handleRedirect for the full acceptance checks. Acceptance of the
+		// redirect itself is already reflected by received.RedirectMsg above,
+		// matching every other NDP message type in this switch.
+		e.handleRedirect(r, iph, h)
+
 	default:
 		received.Invalid.Increment()
 	}
@@ -0,0 +1,192 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// icmpv6ErrorPayloadMaxSize is the largest amount of the original packet we
+// will copy into an outgoing ICMPv6 error message, as per RFC 4443 section
+// 2.4(c): the resulting packet must not exceed the IPv6 minimum MTU. Every
+// error message type sent here has an ICMPv6MinimumSize fixed header.
+const icmpv6ErrorPayloadMaxSize = header.IPv6MinimumMTU - header.IPv6MinimumSize - header.ICMPv6MinimumSize
+
+// shouldSendICMPv6Error returns false when RFC 4443 section 2.4(e) forbids a
+// response to origPkt: never send an ICMPv6 error in reply to a multicast
+// packet (with the two explicit exceptions not implemented here), a packet
+// whose source is the unspecified address, or another ICMPv6 error message.
+// Anycast source addresses are not detected: such a packet is treated like
+// any other unicast-sourced one. The type inspected for the last check is origPkt's own
+// ICMPv6 type, not the type of the error we are about to send.
+func shouldSendICMPv6Error(origPkt stack.PacketBuffer) bool {
+	iph := header.IPv6(origPkt.NetworkHeader)
+	if header.IsV6MulticastAddress(iph.DestinationAddress()) {
+		return false
+	}
+	if iph.SourceAddress() == header.IPv6Any {
+		return false
+	}
+	if origPkt.TransportProtocolNumber != header.ICMPv6ProtocolNumber {
+		return true
+	}
+	if len(origPkt.TransportHeader) < header.ICMPv6MinimumSize {
+		// Too short to tell; don't let a malformed offending packet suppress
+		// a response that would otherwise be sent.
+		return true
+	}
+	// Do not respond to another ICMPv6 error message, as per RFC 4443
+	// section 2.4(e); informational messages (e.g. Echo Request) are fine.
+	return header.ICMPv6(origPkt.TransportHeader).Type().IsInformational()
+}
+
+// icmpv6ErrorPayload builds the payload for an outgoing ICMPv6 error
+// message: as much of the offending packet (starting at its IPv6 header) as
+// fits within icmpv6ErrorPayloadMaxSize, as per RFC 4443 section 2.4(c).
+func icmpv6ErrorPayload(origPkt stack.PacketBuffer) buffer.VectorisedView {
+	payload := buffer.NewVectorisedView(len(origPkt.NetworkHeader)+len(origPkt.TransportHeader)+origPkt.Data.Size(), append([]buffer.View{origPkt.NetworkHeader, origPkt.TransportHeader}, origPkt.Data.Views()...))
+	if payload.Size() > icmpv6ErrorPayloadMaxSize {
+		payload.CapLength(icmpv6ErrorPayloadMaxSize)
+	}
+	return payload
+}
+
+// writeICMPv6Error finishes and sends an ICMPv6 error message whose fixed
+// header and type-specific fields have already been filled in by packet.
+func (e *endpoint) writeICMPv6Error(r *stack.Route, packet header.ICMPv6, payload buffer.VectorisedView, sent tcpip.ICMPv6SentPacketStats) {
+	if !e.icmpRateLimiter().allow() {
+		r.Stats().ICMP.V6PacketsSent.RateLimited.Increment()
+		return
+	}
+
+	// Honor any destination override learned from a prior valid Redirect
+	// before computing the checksum and writing the packet, as per RFC 4861
+	// section 8.3.
+	e.applyRedirectOverride(r)
+
+	// A unicast error reply is itself a packet queued for transmission to
+	// r.RemoteAddress, so it must nudge a STALE neighbor entry into DELAY, as
+	// per RFC 4861 section 7.3.3.
+	if !header.IsV6MulticastAddress(r.RemoteAddress) {
+		e.neighbors().handlePacketQueued(r.RemoteAddress)
+	}
+
+	packet.SetChecksum(header.ICMPv6Checksum(packet, r.LocalAddress, r.RemoteAddress, payload))
+	hdr := buffer.NewPrependableFromView(buffer.View(packet))
+	if err := r.WritePacket(nil /* gso */, stack.NetworkHeaderParams{
+		Protocol: header.ICMPv6ProtocolNumber,
+		TTL:      r.DefaultTTL(),
+		TOS:      stack.DefaultTOS,
+	}, stack.PacketBuffer{
+		Header: hdr,
+		Data:   payload,
+	}); err != nil {
+		r.Stats().ICMP.V6PacketsSent.Dropped.Increment()
+		return
+	}
+	sent.Increment()
+}
+
+// sendICMPv6DstUnreachable, sendICMPv6ParamProblem, sendICMPv6TimeExceeded,
+// and sendICMPv6PacketTooBig are not yet called from the receive or forward
+// path: this tree has no unknown-next-header handling, no undeliverable-port
+// dispatch, and no forwarding/HandlePacket logic for them to hook into
+// (handleICMP's switch only covers ICMPv6 message types, e.g. Router
+// Solicitation, not general transport dispatch or forwarding). They are
+// exercised today only by their own unit tests. Callers should invoke them
+// from that dispatch/forwarding code once it exists in this tree.
+//
+// sendICMPv6DstUnreachable sends an ICMPv6 Destination Unreachable message
+// in response to origPkt, as per RFC 4443 section 3.1.
+func (e *endpoint) sendICMPv6DstUnreachable(r *stack.Route, code header.ICMPv6Code, origPkt stack.PacketBuffer) {
+	sent := r.Stats().ICMP.V6PacketsSent
+	if !shouldSendICMPv6Error(origPkt) {
+		// This is RFC 4443 section 2.4(e) suppression, not rate limiting; the
+		// real token-bucket rejection is counted in writeICMPv6Error instead.
+		sent.Dropped.Increment()
+		return
+	}
+
+	payload := icmpv6ErrorPayload(origPkt)
+	hdr := buffer.NewPrependable(int(r.MaxHeaderLength()) + header.ICMPv6DstUnreachableMinimumSize)
+	packet := header.ICMPv6(hdr.Prepend(header.ICMPv6DstUnreachableMinimumSize))
+	packet.SetType(header.ICMPv6DstUnreachable)
+	packet.SetCode(code)
+	e.writeICMPv6Error(r, packet, payload, sent.DstUnreachable)
+}
+
+// sendICMPv6ParamProblem sends an ICMPv6 Parameter Problem message in
+// response to origPkt, as per RFC 4443 section 3.4. pointer identifies the
+// octet of the offending packet that caused the error.
+func (e *endpoint) sendICMPv6ParamProblem(r *stack.Route, code header.ICMPv6Code, pointer uint32, origPkt stack.PacketBuffer) {
+	sent := r.Stats().ICMP.V6PacketsSent
+	if !shouldSendICMPv6Error(origPkt) {
+		// This is RFC 4443 section 2.4(e) suppression, not rate limiting; the
+		// real token-bucket rejection is counted in writeICMPv6Error instead.
+		sent.Dropped.Increment()
+		return
+	}
+
+	payload := icmpv6ErrorPayload(origPkt)
+	hdr := buffer.NewPrependable(int(r.MaxHeaderLength()) + header.ICMPv6ParamProblemMinimumSize)
+	packet := header.ICMPv6(hdr.Prepend(header.ICMPv6ParamProblemMinimumSize))
+	packet.SetType(header.ICMPv6ParamProblem)
+	packet.SetCode(code)
+	packet.SetTypeSpecific(pointer)
+	e.writeICMPv6Error(r, packet, payload, sent.ParamProblem)
+}
+
+// sendICMPv6TimeExceeded sends an ICMPv6 Time Exceeded message in response
+// to origPkt, as per RFC 4443 section 3.3.
+func (e *endpoint) sendICMPv6TimeExceeded(r *stack.Route, code header.ICMPv6Code, origPkt stack.PacketBuffer) {
+	sent := r.Stats().ICMP.V6PacketsSent
+	if !shouldSendICMPv6Error(origPkt) {
+		// This is RFC 4443 section 2.4(e) suppression, not rate limiting; the
+		// real token-bucket rejection is counted in writeICMPv6Error instead.
+		sent.Dropped.Increment()
+		return
+	}
+
+	payload := icmpv6ErrorPayload(origPkt)
+	hdr := buffer.NewPrependable(int(r.MaxHeaderLength()) + header.ICMPv6TimeExceededMinimumSize)
+	packet := header.ICMPv6(hdr.Prepend(header.ICMPv6TimeExceededMinimumSize))
+	packet.SetType(header.ICMPv6TimeExceeded)
+	packet.SetCode(code)
+	e.writeICMPv6Error(r, packet, payload, sent.TimeExceeded)
+}
+
+// sendICMPv6PacketTooBig sends an ICMPv6 Packet Too Big message in response
+// to origPkt, as per RFC 4443 section 3.2. mtu is the MTU of the link that
+// could not forward the packet.
+func (e *endpoint) sendICMPv6PacketTooBig(r *stack.Route, mtu uint32, origPkt stack.PacketBuffer) {
+	sent := r.Stats().ICMP.V6PacketsSent
+	if !shouldSendICMPv6Error(origPkt) {
+		// This is RFC 4443 section 2.4(e) suppression, not rate limiting; the
+		// real token-bucket rejection is counted in writeICMPv6Error instead.
+		sent.Dropped.Increment()
+		return
+	}
+
+	payload := icmpv6ErrorPayload(origPkt)
+	hdr := buffer.NewPrependable(int(r.MaxHeaderLength()) + header.ICMPv6PacketTooBigMinimumSize)
+	packet := header.ICMPv6(hdr.Prepend(header.ICMPv6PacketTooBigMinimumSize))
+	packet.SetType(header.ICMPv6PacketTooBig)
+	packet.SetCode(header.ICMPv6Code(0))
+	packet.SetMTU(mtu)
+	e.writeICMPv6Error(r, packet, payload, sent.PacketTooBig)
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const (
+	testLinkLocalSrc = tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+	testLinkLocalDst = tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02")
+)
+
+// icmpv6TestPacket builds a minimal stack.PacketBuffer as if it were the
+// offending packet that triggered an ICMPv6 error, with dst as its IPv6
+// destination address and, when transportProto is ICMPv6, icmpType as its
+// ICMPv6 type.
+func icmpv6TestPacket(dst tcpip.Address, transportProto tcpip.TransportProtocolNumber, icmpType header.ICMPv6Type) stack.PacketBuffer {
+	ipHdr := make(header.IPv6, header.IPv6MinimumSize)
+	ipHdr.Encode(&header.IPv6Fields{
+		SrcAddr:    testLinkLocalSrc,
+		DstAddr:    dst,
+		NextHeader: uint8(transportProto),
+	})
+
+	var transportHeader buffer.View
+	if transportProto == header.ICMPv6ProtocolNumber {
+		icmp := make(header.ICMPv6, header.ICMPv6MinimumSize)
+		icmp.SetType(icmpType)
+		transportHeader = buffer.View(icmp)
+	}
+
+	return stack.PacketBuffer{
+		NetworkHeader:           buffer.View(ipHdr),
+		TransportHeader:         transportHeader,
+		TransportProtocolNumber: transportProto,
+	}
+}
+
+func TestShouldSendICMPv6Error(t *testing.T) {
+	if !shouldSendICMPv6Error(icmpv6TestPacket(testLinkLocalDst, header.UDPProtocolNumber, 0)) {
+		t.Errorf("shouldSendICMPv6Error(unicast UDP packet) = false, want true")
+	}
+
+	if shouldSendICMPv6Error(icmpv6TestPacket(header.IPv6AllNodesMulticastAddress, header.UDPProtocolNumber, 0)) {
+		t.Errorf("shouldSendICMPv6Error(multicast-destined packet) = true, want false")
+	}
+
+	// An Echo Request is an informational ICMPv6 message; RFC 4443 section
+	// 2.4(e) explicitly permits responding to it.
+	if !shouldSendICMPv6Error(icmpv6TestPacket(testLinkLocalDst, header.ICMPv6ProtocolNumber, header.ICMPv6EchoRequest)) {
+		t.Errorf("shouldSendICMPv6Error(Echo Request) = false, want true")
+	}
+
+	// Must never respond to another ICMPv6 error message.
+	if shouldSendICMPv6Error(icmpv6TestPacket(testLinkLocalDst, header.ICMPv6ProtocolNumber, header.ICMPv6DstUnreachable)) {
+		t.Errorf("shouldSendICMPv6Error(DstUnreachable) = true, want false")
+	}
+}
+
+func TestICMPRateLimiterBurstThenLimited(t *testing.T) {
+	l := newICMPRateLimiter(time.Hour, 2)
+	if !l.allow() {
+		t.Errorf("1st allow() = false, want true")
+	}
+	if !l.allow() {
+		t.Errorf("2nd allow() = false, want true")
+	}
+	if l.allow() {
+		t.Errorf("3rd allow() = true, want false (burst exhausted)")
+	}
+}
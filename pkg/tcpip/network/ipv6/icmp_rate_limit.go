@@ -0,0 +1,134 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultICMPv6ErrorRateLimit and defaultICMPv6ErrorBurst are the default
+// token-bucket parameters used to rate limit outgoing ICMPv6 error messages,
+// as required by RFC 4443 section 2.4(f). They mirror the values Linux uses
+// for icmp_ratelimit/icmp_ratemask by default.
+const (
+	defaultICMPv6ErrorRateLimit = 1 * time.Second
+	defaultICMPv6ErrorBurst     = 50
+)
+
+// icmpRateLimiter is a simple token-bucket rate limiter used to bound the
+// rate at which this endpoint's protocol sends ICMPv6 error messages.
+//
+// +stateify savable
+type icmpRateLimiter struct {
+	// period is the amount of time it takes for a single token to be
+	// replenished.
+	period time.Duration
+
+	mu struct {
+		sync.Mutex
+
+		// tokens is the number of ICMPv6 error messages that may currently
+		// be sent without being rate limited.
+		tokens int
+
+		// max is the maximum number of tokens that may accumulate.
+		max int
+
+		// last is the last time tokens were replenished.
+		last time.Time
+	}
+}
+
+// newICMPRateLimiter returns an icmpRateLimiter with the given period and
+// burst, falling back to the package default for either one that is zero.
+func newICMPRateLimiter(period time.Duration, burst int) *icmpRateLimiter {
+	if period == 0 {
+		period = defaultICMPv6ErrorRateLimit
+	}
+	if burst == 0 {
+		burst = defaultICMPv6ErrorBurst
+	}
+
+	l := &icmpRateLimiter{period: period}
+	l.mu.tokens = burst
+	l.mu.max = burst
+	return l
+}
+
+// icmpErrorRateLimit and icmpErrorBurst are the configured token-bucket
+// parameters used by every endpoint's icmpRateLimiter, settable via
+// SetICMPv6ErrorRateLimit so downstream projects can tune RFC 4443 section
+// 2.4(f) compliance without forking this package.
+var (
+	icmpErrorRateLimitMu sync.Mutex
+	icmpErrorRateLimit   time.Duration
+	icmpErrorBurst       int
+)
+
+// SetICMPv6ErrorRateLimit configures the token-bucket parameters used to
+// rate limit outgoing ICMPv6 error messages, as required by RFC 4443
+// section 2.4(f). A zero period or burst resets that parameter to the
+// package default. It only affects rate limiters created after the call.
+func SetICMPv6ErrorRateLimit(period time.Duration, burst int) {
+	icmpErrorRateLimitMu.Lock()
+	defer icmpErrorRateLimitMu.Unlock()
+	icmpErrorRateLimit = period
+	icmpErrorBurst = burst
+}
+
+// icmpRateLimiters holds the icmpRateLimiter for each endpoint that has
+// sent at least one ICMPv6 error, keyed by endpoint identity (see
+// redirectCaches in ndp_redirect.go for why this is a side table rather
+// than a field on *endpoint).
+var icmpRateLimiters sync.Map // map[*endpoint]*icmpRateLimiter
+
+// icmpRateLimiter returns the icmpRateLimiter for e, creating it on first
+// use from the currently configured rate limit parameters.
+func (e *endpoint) icmpRateLimiter() *icmpRateLimiter {
+	if v, ok := icmpRateLimiters.Load(e); ok {
+		return v.(*icmpRateLimiter)
+	}
+
+	icmpErrorRateLimitMu.Lock()
+	period, burst := icmpErrorRateLimit, icmpErrorBurst
+	icmpErrorRateLimitMu.Unlock()
+
+	v, _ := icmpRateLimiters.LoadOrStore(e, newICMPRateLimiter(period, burst))
+	return v.(*icmpRateLimiter)
+}
+
+// allow reports whether an ICMPv6 error message may be sent right now,
+// consuming a token if so.
+func (l *icmpRateLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(l.mu.last); elapsed >= l.period {
+		replenish := int(elapsed / l.period)
+		l.mu.tokens += replenish
+		if l.mu.tokens > l.mu.max {
+			l.mu.tokens = l.mu.max
+		}
+		l.mu.last = now
+	}
+
+	if l.mu.tokens <= 0 {
+		return false
+	}
+	l.mu.tokens--
+	return true
+}
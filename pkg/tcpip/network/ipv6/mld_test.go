@@ -0,0 +1,176 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestRouterAlertHopByHopOptionShape(t *testing.T) {
+	// RFC 2710 section 3 gives this exact 8-octet sequence (less the Next
+	// Header octet, which prependRouterAlert fills in per call) as the
+	// Router Alert Hop-by-Hop Options header every MLD message must carry.
+	want := [8]byte{0, 0, 5, 2, 0, 0, 1, 0}
+	if ipv6RouterAlertHopByHopOption != want {
+		t.Errorf("ipv6RouterAlertHopByHopOption = %v, want %v", ipv6RouterAlertHopByHopOption, want)
+	}
+	if len(ipv6RouterAlertHopByHopOption)%8 != 0 {
+		t.Errorf("len(ipv6RouterAlertHopByHopOption) = %d, want a multiple of 8", len(ipv6RouterAlertHopByHopOption))
+	}
+}
+
+func TestMLDHandleQuerySetsQuerierVersion(t *testing.T) {
+	m := newMLDState(nil)
+
+	ipHdr := make(header.IPv6, header.IPv6MinimumSize)
+	ipHdr.Encode(&header.IPv6Fields{
+		SrcAddr:    testLinkLocalSrc,
+		DstAddr:    header.IPv6AllNodesMulticastAddress,
+		NextHeader: uint8(header.ICMPv6ProtocolNumber),
+	})
+	mldHdr := make(header.MLD, header.MLDMinimumSize)
+
+	m.handleQuery(ipHdr, mldHdr, false /* isV2 */)
+	m.mu.Lock()
+	got := m.mu.querierVersion
+	m.mu.Unlock()
+	if got != mldQuerierV1 {
+		t.Errorf("querierVersion after an MLDv1 Query = %v, want mldQuerierV1", got)
+	}
+
+	m.handleQuery(ipHdr, mldHdr, true /* isV2 */)
+	m.mu.Lock()
+	got = m.mu.querierVersion
+	m.mu.Unlock()
+	if got != mldQuerierV2 {
+		t.Errorf("querierVersion after an MLDv2 Query = %v, want mldQuerierV2", got)
+	}
+}
+
+func TestMLDSetQuerierStartsAndStopsTimer(t *testing.T) {
+	m := newMLDState(nil)
+
+	m.setQuerier(true)
+	m.mu.Lock()
+	hasTimer := m.mu.queryTimer != nil
+	m.mu.Unlock()
+	if !hasTimer {
+		t.Fatalf("setQuerier(true) did not start the periodic query timer")
+	}
+
+	m.setQuerier(false)
+	m.mu.Lock()
+	hasTimer = m.mu.queryTimer != nil
+	m.mu.Unlock()
+	if hasTimer {
+		t.Errorf("setQuerier(false) did not stop the periodic query timer")
+	}
+}
+
+func TestMLDv2ReportRecord(t *testing.T) {
+	const groupAddr = tcpip.Address("\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	// Exercise the record-building logic writeV2Report uses via
+	// mldv2ReportRecord directly, independent of the packet plumbing around
+	// it (TestMLDWriteV2ReportWritesThroughLinkEndpoint covers that).
+	record := mldv2ReportRecord(groupAddr)
+
+	if len(record) != mldv2ReportRecordSize {
+		t.Fatalf("len(record) = %d, want %d", len(record), mldv2ReportRecordSize)
+	}
+	if record[0] != mldv2ModeIsExclude {
+		t.Errorf("Record Type = %d, want MODE_IS_EXCLUDE (%d)", record[0], mldv2ModeIsExclude)
+	}
+	if record[2] != 0 || record[3] != 0 {
+		t.Errorf("Number of Sources = %d%d, want 0", record[2], record[3])
+	}
+	if got := tcpip.Address(record[4:20]); got != groupAddr {
+		t.Errorf("Multicast Address = %s, want %s", got, groupAddr)
+	}
+}
+
+func TestMLDWriteMessageWritesThroughLinkEndpoint(t *testing.T) {
+	const groupAddr = tcpip.Address("\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	linkEP := &fakeLinkEndpoint{}
+	e := &endpoint{id: stack.NetworkEndpointID{LocalAddress: testLinkLocalSrc}, linkEP: linkEP}
+	m := newMLDState(e)
+
+	if err := m.writeMessage(header.ICMPv6MulticastListenerReport, groupAddr, groupAddr, 0); err != nil {
+		t.Fatalf("writeMessage(...) = %s, want nil", err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Fatalf("writeMessage did not write a packet through e.linkEP")
+	}
+
+	ip := header.IPv6(linkEP.lastPkt.Header.View())
+	icmp := header.ICMPv6(ip[header.IPv6MinimumSize+len(ipv6RouterAlertHopByHopOption):])
+	if got, want := icmp.Type(), header.ICMPv6MulticastListenerReport; got != want {
+		t.Errorf("ICMPv6 Type = %d, want %d", got, want)
+	}
+	if got := header.MLD(icmp.MessageBody()).MulticastAddress(); got != groupAddr {
+		t.Errorf("Multicast Address = %s, want %s", got, groupAddr)
+	}
+}
+
+func TestMLDWriteV2ReportWritesThroughLinkEndpoint(t *testing.T) {
+	const groupAddr = tcpip.Address("\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	linkEP := &fakeLinkEndpoint{}
+	e := &endpoint{id: stack.NetworkEndpointID{LocalAddress: testLinkLocalSrc}, linkEP: linkEP}
+	m := newMLDState(e)
+
+	if err := m.writeV2Report(groupAddr); err != nil {
+		t.Fatalf("writeV2Report(...) = %s, want nil", err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Fatalf("writeV2Report did not write a packet through e.linkEP")
+	}
+
+	ip := header.IPv6(linkEP.lastPkt.Header.View())
+	icmp := header.ICMPv6(ip[header.IPv6MinimumSize+len(ipv6RouterAlertHopByHopOption):])
+	if got, want := icmp.Type(), header.ICMPv6MulticastListenerV2Report; got != want {
+		t.Errorf("ICMPv6 Type = %d, want %d", got, want)
+	}
+	if got := tcpip.Address(icmp.MessageBody()[8:24]); got != groupAddr {
+		t.Errorf("Multicast Address = %s, want %s", got, groupAddr)
+	}
+}
+
+func TestMLDJoinGroupSendsUnsolicitedReport(t *testing.T) {
+	const groupAddr = tcpip.Address("\xff\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01")
+
+	linkEP := &fakeLinkEndpoint{}
+	e := &endpoint{id: stack.NetworkEndpointID{LocalAddress: testLinkLocalSrc}, linkEP: linkEP}
+
+	if err := e.JoinGroup(groupAddr); err != nil {
+		t.Fatalf("JoinGroup(%s) = %s, want nil", groupAddr, err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Errorf("JoinGroup did not send an unsolicited Report")
+	}
+
+	linkEP.writePacketCalled = false
+	if err := e.LeaveGroup(groupAddr); err != nil {
+		t.Fatalf("LeaveGroup(%s) = %s, want nil", groupAddr, err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Errorf("LeaveGroup did not send a Done message")
+	}
+}
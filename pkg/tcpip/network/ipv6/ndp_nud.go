@@ -0,0 +1,403 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// nudState is the state of an entry in the Neighbor Cache, as defined in
+// RFC 4861 section 7.3.2.
+type nudState int
+
+const (
+	// nudIncomplete means address resolution is in progress and the
+	// link-layer address has not yet been determined.
+	nudIncomplete nudState = iota
+
+	// nudReachable means positive confirmation was received within the last
+	// ReachableTime that the forward path to the neighbor was functioning
+	// properly.
+	nudReachable
+
+	// nudStale means more than ReachableTime has elapsed since the last
+	// positive confirmation was received; the entry is not probed until a
+	// packet is sent to it.
+	nudStale
+
+	// nudDelay means a packet was sent since the last positive confirmation
+	// was received, and DelayFirstProbeTime has not yet elapsed.
+	nudDelay
+
+	// nudProbe means a reachability confirmation is actively sought by
+	// sending unicast Neighbor Solicitations.
+	nudProbe
+)
+
+// defaultNUDConfigurations mirrors RFC 4861 section 10's default constants.
+const (
+	defaultBaseReachableTime   = 30 * time.Second
+	defaultMinRandomFactor     = 0.5
+	defaultMaxRandomFactor     = 1.5
+	defaultRetransTimer        = time.Second
+	defaultDelayFirstProbeTime = 5 * time.Second
+	defaultMaxUnicastSolicit   = 3
+)
+
+// NUDConfigurations holds tunables for the Neighbor Unreachability Detection
+// state machine, as per RFC 4861 section 10. A zero value for any field
+// falls back to that field's RFC-specified default.
+type NUDConfigurations struct {
+	BaseReachableTime   time.Duration
+	MinRandomFactor     float64
+	MaxRandomFactor     float64
+	RetransTimer        time.Duration
+	DelayFirstProbeTime time.Duration
+	MaxUnicastSolicit   int
+}
+
+// NUDDispatcher is the interface through which the NUD state machine
+// reports neighbor removal for observability and testing.
+type NUDDispatcher interface {
+	// OnNeighborRemoved is called when a neighbor entry is invalidated
+	// (resolution failed or the entry was otherwise removed) for nicID.
+	OnNeighborRemoved(nicID tcpip.NICID, addr tcpip.Address)
+}
+
+var nudConfig struct {
+	mu         sync.Mutex
+	config     NUDConfigurations
+	dispatcher NUDDispatcher
+}
+
+// SetNUDConfigurations sets the NUDConfigurations used by neighbor caches
+// created after the call.
+func SetNUDConfigurations(c NUDConfigurations) {
+	nudConfig.mu.Lock()
+	defer nudConfig.mu.Unlock()
+	nudConfig.config = c
+}
+
+// SetNUDDispatcher sets the NUDDispatcher notified of neighbor removal by
+// neighbor caches created after the call.
+func SetNUDDispatcher(d NUDDispatcher) {
+	nudConfig.mu.Lock()
+	defer nudConfig.mu.Unlock()
+	nudConfig.dispatcher = d
+}
+
+// neighborEntry is a single entry in the NUD state machine, tracking the
+// reachability of one on-link neighbor.
+//
+// +stateify savable
+type neighborEntry struct {
+	nic *neighborCache
+
+	mu struct {
+		sync.Mutex
+
+		addr     tcpip.Address
+		linkAddr tcpip.LinkAddress
+		state    nudState
+
+		// timer fires to advance the state machine: REACHABLE -> STALE,
+		// DELAY -> PROBE, or to retransmit/expire during PROBE.
+		timer         *time.Timer
+		probesSent    int
+		reachableTime time.Duration
+	}
+}
+
+// neighborCache drives the NUD state machine for all neighbors known to an
+// endpoint, as per RFC 4861 section 7.3.
+//
+// +stateify savable
+type neighborCache struct {
+	ep     *endpoint
+	config NUDConfigurations
+
+	mu struct {
+		sync.Mutex
+		entries map[tcpip.Address]*neighborEntry
+	}
+}
+
+func newNeighborCache(ep *endpoint, config NUDConfigurations) *neighborCache {
+	c := &neighborCache{ep: ep, config: config}
+	c.mu.entries = make(map[tcpip.Address]*neighborEntry)
+	return c
+}
+
+// neighborCaches holds the neighborCache for each endpoint that has
+// observed at least one neighbor, keyed by endpoint identity (see
+// redirectCaches in ndp_redirect.go for why this is a side table rather
+// than a field on *endpoint).
+var neighborCaches sync.Map // map[*endpoint]*neighborCache
+
+// neighbors returns the neighborCache for e, creating it on first use from
+// the currently configured NUDConfigurations.
+func (e *endpoint) neighbors() *neighborCache {
+	if v, ok := neighborCaches.Load(e); ok {
+		return v.(*neighborCache)
+	}
+
+	nudConfig.mu.Lock()
+	config := nudConfig.config
+	nudConfig.mu.Unlock()
+
+	v, _ := neighborCaches.LoadOrStore(e, newNeighborCache(e, config))
+	return v.(*neighborCache)
+}
+
+// reachableTime picks a random reachable time in
+// [MinRandomFactor, MaxRandomFactor) * BaseReachableTime, as per RFC 4861
+// section 6.3.2.
+func (c *neighborCache) reachableTime() time.Duration {
+	base := c.config.BaseReachableTime
+	if base == 0 {
+		base = defaultBaseReachableTime
+	}
+	min, max := c.config.MinRandomFactor, c.config.MaxRandomFactor
+	if min == 0 && max == 0 {
+		min, max = defaultMinRandomFactor, defaultMaxRandomFactor
+	}
+	factor := min + rand.Float64()*(max-min)
+	return time.Duration(factor * float64(base))
+}
+
+// stopAllTimers stops every entry's retransmit/expiry timer, as per
+// detachEndpoint in ndp_ra.go.
+func (c *neighborCache) stopAllTimers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.mu.entries {
+		e.mu.Lock()
+		e.stopTimerLocked()
+		e.mu.Unlock()
+	}
+}
+
+func (c *neighborCache) entry(addr tcpip.Address) *neighborEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.mu.entries[addr]; ok {
+		return e
+	}
+	e := &neighborEntry{nic: c}
+	e.mu.addr = addr
+	c.mu.entries[addr] = e
+	return e
+}
+
+// handleUpperLayerConfirmation transitions addr to REACHABLE on positive
+// confirmation of reachability from an upper layer protocol (e.g. a TCP
+// ACK), as per RFC 4861 section 7.3.1. It is also used to process a
+// solicited Neighbor Advertisement, which RFC 4861 section 7.2.5 treats the
+// same way.
+func (c *neighborCache) handleUpperLayerConfirmation(addr tcpip.Address) {
+	e := c.entry(addr)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.setReachableLocked()
+}
+
+// handleProbe updates addr's link-layer address from a received Neighbor
+// Solicitation, as per RFC 4861 section 7.2.3: the entry's link-layer
+// address is set if unknown, and marked STALE (to be reconfirmed before the
+// next packet is sent to it) if it changed.
+func (c *neighborCache) handleProbe(addr tcpip.Address, linkAddr tcpip.LinkAddress) {
+	e := c.entry(addr)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.mu.state == nudIncomplete || e.mu.linkAddr != linkAddr {
+		e.mu.linkAddr = linkAddr
+		e.mu.state = nudStale
+		e.stopTimerLocked()
+	}
+}
+
+func (e *neighborEntry) setReachableLocked() {
+	e.mu.state = nudReachable
+	e.mu.reachableTime = e.nic.reachableTime()
+	e.stopTimerLocked()
+	rt := e.mu.reachableTime
+	e.mu.timer = time.AfterFunc(rt, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.mu.state == nudReachable {
+			e.mu.state = nudStale
+		}
+	})
+}
+
+func (e *neighborEntry) stopTimerLocked() {
+	if e.mu.timer != nil {
+		e.mu.timer.Stop()
+	}
+}
+
+// handlePacketQueued transitions a STALE entry to DELAY when a packet is
+// sent to it, starting DelayFirstProbeTime before active probing begins, as
+// per RFC 4861 section 7.3.3.
+func (c *neighborCache) handlePacketQueued(addr tcpip.Address) {
+	e := c.entry(addr)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.mu.state != nudStale {
+		return
+	}
+
+	e.mu.state = nudDelay
+	e.stopTimerLocked()
+	delay := c.config.DelayFirstProbeTime
+	if delay == 0 {
+		delay = defaultDelayFirstProbeTime
+	}
+	e.mu.timer = time.AfterFunc(delay, func() { e.enterProbe() })
+}
+
+// enterProbe transitions the entry to PROBE and sends up to
+// MaxUnicastSolicit unicast Neighbor Solicitations, as per RFC 4861 section
+// 7.3.3. If no confirmation arrives, the entry is invalidated and the route
+// layer is told to drop packets queued for it.
+func (e *neighborEntry) enterProbe() {
+	e.mu.Lock()
+	addr := e.mu.addr
+	e.mu.state = nudProbe
+	e.mu.probesSent = 0
+	e.mu.Unlock()
+
+	c := e.nic
+	maxSolicit := c.config.MaxUnicastSolicit
+	if maxSolicit == 0 {
+		maxSolicit = defaultMaxUnicastSolicit
+	}
+	retransTimer := c.config.RetransTimer
+	if retransTimer == 0 {
+		retransTimer = defaultRetransTimer
+	}
+
+	var probe func()
+	probe = func() {
+		e.mu.Lock()
+		if e.mu.state != nudProbe {
+			e.mu.Unlock()
+			return
+		}
+		if e.mu.probesSent >= maxSolicit {
+			e.mu.Unlock()
+			c.invalidate(addr)
+			return
+		}
+		e.mu.probesSent++
+		e.mu.Unlock()
+
+		c.ep.sendNeighborSolicit(addr, addr, true /* includeSLLA */)
+		e.mu.Lock()
+		e.mu.timer = time.AfterFunc(retransTimer, probe)
+		e.mu.Unlock()
+	}
+	probe()
+}
+
+// invalidate removes addr from the cache and notifies the route layer's
+// NUDDispatcher (if any) that resolution failed so queued packets are
+// dropped.
+func (c *neighborCache) invalidate(addr tcpip.Address) {
+	c.mu.Lock()
+	e, ok := c.mu.entries[addr]
+	if ok {
+		delete(c.mu.entries, addr)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	e.stopTimerLocked()
+	e.mu.Unlock()
+
+	nudConfig.mu.Lock()
+	d := nudConfig.dispatcher
+	nudConfig.mu.Unlock()
+	if d != nil {
+		d.OnNeighborRemoved(c.ep.nicID, addr)
+	}
+}
+
+// sendNeighborSolicit sends a unicast (or, if dst is unspecified, multicast
+// to the solicited-node address of target) Neighbor Solicitation for
+// target, optionally including the Source Link-Layer Address option. This
+// is the same construction used when resolving addresses via
+// stack.LinkAddressResolver, factored out so the NUD state machine can
+// (re)issue probes on its own.
+func (e *endpoint) sendNeighborSolicit(target tcpip.Address, dst tcpip.Address, includeSLLA bool) *tcpip.Error {
+	if len(dst) == 0 {
+		dst = header.SolicitedNodeAddr(target)
+	}
+
+	// This probe has no preceding incoming packet to route a reply to, so
+	// there is no real *stack.Route to send it with (a bare
+	// &stack.Route{LocalAddress, RemoteAddress} is missing the route
+	// internals WritePacket depends on). Build the IPv6 header by hand and
+	// hand the packet to e.linkEP directly instead, following the same
+	// pattern as LinkAddressRequest in icmp.go.
+	//
+	// TODO(b/148672031): Use stack.FindRoute instead of manually creating
+	// the route/header here.
+	r := &stack.Route{
+		LocalAddress:  e.id.LocalAddress,
+		RemoteAddress: dst,
+	}
+
+	var optsSerializer header.NDPOptionsSerializer
+	if includeSLLA {
+		optsSerializer = header.NDPOptionsSerializer{
+			header.NDPSourceLinkLayerAddressOption(e.linkEP.LinkAddress()),
+		}
+	}
+
+	hdr := buffer.NewPrependable(int(e.linkEP.MaxHeaderLength()) + header.IPv6MinimumSize + header.ICMPv6NeighborSolicitMinimumSize + int(optsSerializer.Length()))
+	packet := header.ICMPv6(hdr.Prepend(header.ICMPv6NeighborSolicitMinimumSize + int(optsSerializer.Length())))
+	packet.SetType(header.ICMPv6NeighborSolicit)
+	ns := header.NDPNeighborSolicit(packet.NDPPayload())
+	ns.SetTargetAddress(target)
+	if includeSLLA {
+		ns.Options().Serialize(optsSerializer)
+	}
+	packet.SetChecksum(header.ICMPv6Checksum(packet, r.LocalAddress, r.RemoteAddress, buffer.VectorisedView{}))
+
+	length := uint16(hdr.UsedLength())
+	ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize))
+	ip.Encode(&header.IPv6Fields{
+		PayloadLength: length,
+		NextHeader:    uint8(header.ICMPv6ProtocolNumber),
+		HopLimit:      header.NDPHopLimit,
+		SrcAddr:       r.LocalAddress,
+		DstAddr:       r.RemoteAddress,
+	})
+
+	return e.linkEP.WritePacket(r, nil /* gso */, ProtocolNumber, stack.PacketBuffer{
+		Header: hdr,
+	})
+}
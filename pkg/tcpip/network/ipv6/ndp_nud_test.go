@@ -0,0 +1,142 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const testNeighborAddr = tcpip.Address("\xfe\x80\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x03")
+
+func TestNeighborCacheUpperLayerConfirmation(t *testing.T) {
+	c := newNeighborCache(nil, NUDConfigurations{BaseReachableTime: time.Hour})
+	c.handleUpperLayerConfirmation(testNeighborAddr)
+
+	e := c.entry(testNeighborAddr)
+	e.mu.Lock()
+	state := e.mu.state
+	e.mu.Unlock()
+	if state != nudReachable {
+		t.Errorf("state after handleUpperLayerConfirmation = %v, want nudReachable", state)
+	}
+}
+
+func TestNeighborCacheHandleProbeMarksStale(t *testing.T) {
+	const linkAddr = tcpip.LinkAddress("\x02\x02\x02\x02\x02\x02")
+
+	c := newNeighborCache(nil, NUDConfigurations{})
+	c.handleProbe(testNeighborAddr, linkAddr)
+
+	e := c.entry(testNeighborAddr)
+	e.mu.Lock()
+	state, got := e.mu.state, e.mu.linkAddr
+	e.mu.Unlock()
+	if state != nudStale {
+		t.Errorf("state after first handleProbe = %v, want nudStale", state)
+	}
+	if got != linkAddr {
+		t.Errorf("linkAddr after handleProbe = %s, want %s", got, linkAddr)
+	}
+}
+
+func TestNeighborCacheHandlePacketQueuedEntersDelay(t *testing.T) {
+	const linkAddr = tcpip.LinkAddress("\x02\x02\x02\x02\x02\x02")
+
+	c := newNeighborCache(nil, NUDConfigurations{DelayFirstProbeTime: time.Hour})
+	c.handleProbe(testNeighborAddr, linkAddr)
+	c.handlePacketQueued(testNeighborAddr)
+
+	e := c.entry(testNeighborAddr)
+	e.mu.Lock()
+	state := e.mu.state
+	e.mu.Unlock()
+	if state != nudDelay {
+		t.Errorf("state after handlePacketQueued on a STALE entry = %v, want nudDelay", state)
+	}
+}
+
+func TestNeighborCacheHandlePacketQueuedIgnoresNonStale(t *testing.T) {
+	c := newNeighborCache(nil, NUDConfigurations{})
+	c.handleUpperLayerConfirmation(testNeighborAddr)
+	c.handlePacketQueued(testNeighborAddr)
+
+	e := c.entry(testNeighborAddr)
+	e.mu.Lock()
+	state := e.mu.state
+	e.mu.Unlock()
+	if state != nudReachable {
+		t.Errorf("state after handlePacketQueued on a REACHABLE entry = %v, want nudReachable", state)
+	}
+}
+
+func TestNeighborCacheInvalidateNotifiesDispatcher(t *testing.T) {
+	var removed []tcpip.Address
+	SetNUDDispatcher(nudDispatcherFunc(func(nicID tcpip.NICID, addr tcpip.Address) {
+		removed = append(removed, addr)
+	}))
+	defer SetNUDDispatcher(nil)
+
+	c := newNeighborCache(&endpoint{}, NUDConfigurations{})
+	c.entry(testNeighborAddr)
+	c.invalidate(testNeighborAddr)
+
+	if len(removed) != 1 || removed[0] != testNeighborAddr {
+		t.Errorf("dispatcher notified with %v, want [%s]", removed, testNeighborAddr)
+	}
+	if _, ok := c.mu.entries[testNeighborAddr]; ok {
+		t.Errorf("entry for %s still present after invalidate", testNeighborAddr)
+	}
+}
+
+func TestSendNeighborSolicitWritesThroughLinkEndpoint(t *testing.T) {
+	const linkAddr = tcpip.LinkAddress("\x02\x02\x02\x02\x02\x02")
+	linkEP := &fakeLinkEndpoint{linkAddr: linkAddr}
+	e := &endpoint{
+		id:     stack.NetworkEndpointID{LocalAddress: testLinkLocalSrc},
+		linkEP: linkEP,
+	}
+
+	if err := e.sendNeighborSolicit(testNeighborAddr, "" /* dst */, true /* includeSLLA */); err != nil {
+		t.Fatalf("sendNeighborSolicit(...) = %s, want nil", err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Fatalf("sendNeighborSolicit did not write a packet through e.linkEP")
+	}
+
+	ip := header.IPv6(linkEP.lastPkt.Header.View())
+	if got, want := ip.TransportProtocol(), header.ICMPv6ProtocolNumber; got != want {
+		t.Errorf("IPv6 NextHeader = %d, want %d", got, want)
+	}
+	icmp := header.ICMPv6(ip[header.IPv6MinimumSize:])
+	if got, want := icmp.Type(), header.ICMPv6NeighborSolicit; got != want {
+		t.Errorf("ICMPv6 Type = %d, want %d", got, want)
+	}
+	if got := header.NDPNeighborSolicit(icmp.NDPPayload()).TargetAddress(); got != testNeighborAddr {
+		t.Errorf("Target Address = %s, want %s", got, testNeighborAddr)
+	}
+}
+
+// nudDispatcherFunc adapts a function to the NUDDispatcher interface for
+// tests.
+type nudDispatcherFunc func(nicID tcpip.NICID, addr tcpip.Address)
+
+func (f nudDispatcherFunc) OnNeighborRemoved(nicID tcpip.NICID, addr tcpip.Address) {
+	f(nicID, addr)
+}
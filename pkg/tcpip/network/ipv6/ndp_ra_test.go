@@ -0,0 +1,152 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+func TestRAAdvertiserSetAdvertisingStartsAndStopsTimer(t *testing.T) {
+	a := newRAAdvertiser(&endpoint{}, NDPRAConfigurations{})
+
+	a.setAdvertising(true)
+	a.mu.Lock()
+	hasTimer := a.mu.timer != nil
+	a.mu.Unlock()
+	if !hasTimer {
+		t.Fatalf("setAdvertising(true) did not start the periodic RA timer")
+	}
+
+	a.setAdvertising(false)
+	a.mu.Lock()
+	hasTimer = a.mu.timer != nil
+	a.mu.Unlock()
+	if hasTimer {
+		t.Errorf("setAdvertising(false) did not stop the periodic RA timer")
+	}
+}
+
+func TestRAAdvertiserHandleRSIgnoredWhenNotAdvertising(t *testing.T) {
+	a := newRAAdvertiser(&endpoint{}, NDPRAConfigurations{})
+
+	// handleRS must not attempt to send (which would panic on the zero-value
+	// endpoint's nil fields) while this NIC isn't an advertising router.
+	a.handleRS(nil)
+}
+
+func TestSetNICForwardingStartsAdvertisingAndQuerying(t *testing.T) {
+	const nicID = tcpip.NICID(7)
+
+	SetNDPRAConfigurations(NDPRAConfigurations{})
+	e := &endpoint{nicID: nicID}
+	attachEndpoint(e)
+
+	SetNICForwarding(nicID, true)
+	defer SetNICForwarding(nicID, false)
+
+	adv := e.raAdvertiser()
+	adv.mu.Lock()
+	advertising := adv.mu.advertising
+	adv.mu.Unlock()
+	if !advertising {
+		t.Errorf("raAdvertiser.advertising = false after SetNICForwarding(nicID, true), want true")
+	}
+
+	m := e.mldState()
+	m.mu.Lock()
+	querier := m.mu.querier
+	m.mu.Unlock()
+	if !querier {
+		t.Errorf("mldState.querier = false after SetNICForwarding(nicID, true), want true")
+	}
+}
+
+func TestSetNICForwardingUnknownNICIsNoop(t *testing.T) {
+	// Must not panic: no endpoint has ever registered under this NIC ID.
+	SetNICForwarding(tcpip.NICID(9999), true)
+}
+
+func TestDetachEndpointReleasesSideTablesAndStopsTimers(t *testing.T) {
+	const nicID = tcpip.NICID(11)
+
+	e := &endpoint{nicID: nicID}
+	attachEndpoint(e)
+
+	adv := e.raAdvertiser()
+	adv.setAdvertising(true)
+	m := e.mldState()
+	m.setQuerier(true)
+	e.neighbors().handleProbe(testNeighborAddr, tcpip.LinkAddress("\x02\x02\x02\x02\x02\x02"))
+	e.neighbors().handleUpperLayerConfirmation(testNeighborAddr)
+
+	detachEndpoint(e)
+
+	if _, ok := raAdvertisers.Load(e); ok {
+		t.Errorf("raAdvertisers still holds e after detachEndpoint")
+	}
+	if _, ok := mldStates.Load(e); ok {
+		t.Errorf("mldStates still holds e after detachEndpoint")
+	}
+	if _, ok := neighborCaches.Load(e); ok {
+		t.Errorf("neighborCaches still holds e after detachEndpoint")
+	}
+	if _, ok := endpointsByNICID.Load(nicID); ok {
+		t.Errorf("endpointsByNICID still holds nicID after detachEndpoint")
+	}
+
+	adv.mu.Lock()
+	hasTimer := adv.mu.timer != nil
+	adv.mu.Unlock()
+	if hasTimer {
+		t.Errorf("raAdvertiser's periodic timer still running after detachEndpoint")
+	}
+
+	m.mu.Lock()
+	hasTimer = m.mu.queryTimer != nil
+	m.mu.Unlock()
+	if hasTimer {
+		t.Errorf("mldState's periodic query timer still running after detachEndpoint")
+	}
+}
+
+func TestRAAdvertiserWriteRAWritesThroughLinkEndpoint(t *testing.T) {
+	linkEP := &fakeLinkEndpoint{}
+	e := &endpoint{id: stack.NetworkEndpointID{LocalAddress: testLinkLocalSrc}, linkEP: linkEP}
+	a := newRAAdvertiser(e, NDPRAConfigurations{})
+
+	// send needs e.stack (through e.advertisedPrefixes) to enumerate the
+	// NIC's assigned prefixes, so it can't run against a bare test *endpoint;
+	// exercise the packet-building/send logic it shares with send via
+	// writeRA directly, rather than the NIC-prefix plumbing around it.
+	if err := a.writeRA(header.IPv6AllNodesMulticastAddress, nil /* prefixes */); err != nil {
+		t.Fatalf("writeRA(...) = %s, want nil", err)
+	}
+	if !linkEP.writePacketCalled {
+		t.Fatalf("writeRA did not write a packet through e.linkEP")
+	}
+
+	ip := header.IPv6(linkEP.lastPkt.Header.View())
+	if got, want := ip.TransportProtocol(), header.ICMPv6ProtocolNumber; got != want {
+		t.Errorf("IPv6 NextHeader = %d, want %d", got, want)
+	}
+	icmp := header.ICMPv6(ip[header.IPv6MinimumSize:])
+	if got, want := icmp.Type(), header.ICMPv6RouterAdvert; got != want {
+		t.Errorf("ICMPv6 Type = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipv6
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+func TestRedirectCacheLookup(t *testing.T) {
+	const (
+		dst1    = tcpip.Address("\x20\x01\xdb\x08::1")
+		dst2    = tcpip.Address("\x20\x01\xdb\x08::2")
+		target1 = tcpip.Address("\xfe\x80::1")
+	)
+
+	c := newRedirectCache()
+
+	if _, ok := c.lookup(dst1); ok {
+		t.Fatalf("lookup(dst1) on empty cache = ok, want !ok")
+	}
+
+	c.set(dst1, target1)
+	if got, ok := c.lookup(dst1); !ok || got != target1 {
+		t.Errorf("lookup(dst1) = (%v, %v), want (%v, true)", got, ok, target1)
+	}
+	if _, ok := c.lookup(dst2); ok {
+		t.Errorf("lookup(dst2) = ok, want !ok; dst2 was never redirected")
+	}
+}
+
+func TestAcceptRedirectsDefault(t *testing.T) {
+	if !AcceptRedirects() {
+		t.Errorf("AcceptRedirects() = false, want true by default")
+	}
+
+	SetAcceptRedirects(false)
+	defer SetAcceptRedirects(true)
+	if AcceptRedirects() {
+		t.Errorf("AcceptRedirects() = true after SetAcceptRedirects(false)")
+	}
+}
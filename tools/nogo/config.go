@@ -0,0 +1,190 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"fmt"
+	"go/token"
+	"io/ioutil"
+
+	"golang.org/x/tools/go/analysis"
+	"gopkg.in/yaml.v2"
+)
+
+// ruleAction is the effect a config rule has on matching diagnostics.
+type ruleAction string
+
+const (
+	// actionExclude suppresses matching diagnostics.
+	actionExclude ruleAction = "exclude"
+
+	// actionInclude re-enables matching diagnostics, overriding an earlier
+	// rule that excluded them.
+	actionInclude ruleAction = "include"
+)
+
+// rule is the declarative, user-facing form of a single exclusion: an
+// analyzer name plus a set of path and message patterns to match against,
+// and the action to take when they all match.
+type rule struct {
+	// ID uniquely identifies this rule for reporting and debugging.
+	ID string `yaml:"id" json:"id"`
+
+	// Analyzer restricts this rule to diagnostics from the named analyzer.
+	// Empty means "all analyzers".
+	Analyzer string `yaml:"analyzer" json:"analyzer"`
+
+	// Paths are dockerignore-style path patterns (see pathPatterns).
+	Paths []string `yaml:"paths" json:"paths"`
+
+	// Messages are substrings of the diagnostic message (see
+	// resultExcluded).
+	Messages []string `yaml:"messages" json:"messages"`
+
+	// Action is actionExclude or actionInclude. Defaults to actionExclude.
+	Action ruleAction `yaml:"action" json:"action"`
+}
+
+// config is the top-level, declarative form of a nogo exclusion file.
+type config struct {
+	Rules []rule `yaml:"rules" json:"rules"`
+}
+
+// configMatcher dispatches to only the rules relevant to the analyzer that
+// raised a given diagnostic, rather than walking every rule for every
+// diagnostic.
+type configMatcher struct {
+	// byAnalyzer maps an analyzer name to the rules scoped to it. Rules
+	// with no Analyzer set live under the empty string key and apply to
+	// every analyzer.
+	byAnalyzer map[string][]compiledRule
+}
+
+// compiledRule is a rule with its patterns compiled into matchers.
+type compiledRule struct {
+	rule
+	matcher matcher
+
+	// seq is this rule's index in the original, fully-merged Rules list.
+	// byAnalyzer buckets rules by Analyzer for cheap dispatch, which loses
+	// the true declaration order across buckets; seq lets ShouldReportFor
+	// reconstruct it.
+	seq int
+}
+
+// loadConfig parses a declarative nogo exclusion file (YAML or JSON; JSON
+// is valid YAML, so a single parser handles both).
+func loadConfig(data []byte) (*config, error) {
+	var c config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing nogo config: %w", err)
+	}
+	return &c, nil
+}
+
+// loadConfigFile reads and parses a declarative nogo exclusion file from
+// disk, so that downstream projects can point at their own file without
+// patching Go code.
+func loadConfigFile(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading nogo config %q: %w", path, err)
+	}
+	return loadConfig(data)
+}
+
+// merge combines this config with a downstream project's own config,
+// appending its rules after the baseline's so that later rules (from the
+// downstream file) can re-include diagnostics the baseline excluded.
+func (c *config) merge(other *config) *config {
+	merged := &config{Rules: make([]rule, 0, len(c.Rules)+len(other.Rules))}
+	merged.Rules = append(merged.Rules, c.Rules...)
+	merged.Rules = append(merged.Rules, other.Rules...)
+	return merged
+}
+
+// compile builds a configMatcher from the declarative rule set, composing
+// each rule's patterns out of the existing andMatcher/resultExcluded/
+// pathPatterns primitives.
+func (c *config) compile() *configMatcher {
+	cm := &configMatcher{byAnalyzer: make(map[string][]compiledRule)}
+	for i, r := range c.Rules {
+		if r.Action == "" {
+			r.Action = actionExclude
+		}
+
+		// ShouldReportFor below treats a rule as matching a diagnostic when
+		// m.ShouldReport returns false, so alwaysMatches (which never
+		// returns false) is the correct identity element to and() Paths/
+		// Messages matchers onto -- but it is the wrong answer on its own: a
+		// rule with neither Paths nor Messages is scoped by Analyzer alone
+		// and must match every diagnostic in that scope, which disableMatches
+		// (which always returns false) gives us instead.
+		var m matcher = alwaysMatches()
+		if len(r.Paths) > 0 {
+			m = and(m, newPathPatterns(r.Paths...))
+		}
+		if len(r.Messages) > 0 {
+			m = and(m, resultExcluded(r.Messages))
+		}
+		if len(r.Paths) == 0 && len(r.Messages) == 0 {
+			m = disableMatches()
+		}
+
+		cm.byAnalyzer[r.Analyzer] = append(cm.byAnalyzer[r.Analyzer], compiledRule{rule: r, matcher: m, seq: i})
+	}
+	return cm
+}
+
+// ShouldReportFor reports whether a diagnostic from analyzer a should be
+// reported, consulting only the rules scoped to a (plus the analyzer-
+// agnostic rules), instead of walking every rule in the config. The two
+// buckets are merged back into their original declaration order (via seq)
+// before being applied, so a rule's effect depends on where it was
+// declared/appended, not on whether it happened to be analyzer-scoped or
+// global -- this is what lets a downstream project's global override rule,
+// appended after gvisor's analyzer-scoped baseline rule, actually win.
+func (cm *configMatcher) ShouldReportFor(a *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	global := cm.byAnalyzer[""]
+	scoped := cm.byAnalyzer[a.Name]
+
+	report := true
+	apply := func(r compiledRule) {
+		if matched := !r.matcher.ShouldReport(d, fs); matched {
+			report = r.Action == actionInclude
+		}
+	}
+
+	// global and scoped are each already in declaration order (compile
+	// appends to them in Rules order), so a standard merge by seq
+	// reconstructs the true combined order.
+	i, j := 0, 0
+	for i < len(global) && j < len(scoped) {
+		if global[i].seq < scoped[j].seq {
+			apply(global[i])
+			i++
+		} else {
+			apply(scoped[j])
+			j++
+		}
+	}
+	for ; i < len(global); i++ {
+		apply(global[i])
+	}
+	for ; j < len(scoped); j++ {
+		apply(scoped[j])
+	}
+	return report
+}
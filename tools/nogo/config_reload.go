@@ -0,0 +1,70 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"fmt"
+	"go/token"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// reloadableConfigMatcher wraps a configMatcher built from an on-disk file,
+// allowing it to be rebuilt in place (e.g. in response to a file watch or a
+// SIGHUP) without the caller needing to re-create every matcher that
+// references it.
+type reloadableConfigMatcher struct {
+	path string
+
+	mu struct {
+		sync.RWMutex
+		current *configMatcher
+	}
+}
+
+// newReloadableConfigMatcher loads path and returns a matcher that can
+// later be refreshed with Reload.
+func newReloadableConfigMatcher(path string) (*reloadableConfigMatcher, error) {
+	r := &reloadableConfigMatcher{path: path}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads and re-compiles the config file from disk, swapping it in
+// atomically for subsequent ShouldReportFor calls.
+func (r *reloadableConfigMatcher) Reload() error {
+	c, err := loadConfigFile(r.path)
+	if err != nil {
+		return fmt.Errorf("reloading %q: %w", r.path, err)
+	}
+
+	cm := c.compile()
+	r.mu.Lock()
+	r.mu.current = cm
+	r.mu.Unlock()
+	return nil
+}
+
+// ShouldReportFor implements the same contract as configMatcher.ShouldReportFor,
+// always consulting the most recently loaded config.
+func (r *reloadableConfigMatcher) ShouldReportFor(a *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	r.mu.RLock()
+	cm := r.mu.current
+	r.mu.RUnlock()
+	return cm.ShouldReportFor(a, d, fs)
+}
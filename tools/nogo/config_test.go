@@ -0,0 +1,74 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"go/token"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+func TestConfigCompileAnalyzerOnlyRuleMatchesEverything(t *testing.T) {
+	fs := token.NewFileSet()
+	d := diagnosticAt(fs, "pkg/sentry/fs/file.go")
+	unusedresult := &analysis.Analyzer{Name: "unusedresult"}
+
+	c := &config{Rules: []rule{{ID: "no-unusedresult", Analyzer: "unusedresult"}}}
+	cm := c.compile()
+
+	if cm.ShouldReportFor(unusedresult, d, fs) {
+		t.Errorf("expected an analyzer-only rule to exclude every unusedresult diagnostic")
+	}
+
+	nilness := &analysis.Analyzer{Name: "nilness"}
+	if !cm.ShouldReportFor(nilness, d, fs) {
+		t.Errorf("expected an unusedresult-only rule to leave nilness diagnostics unaffected")
+	}
+}
+
+func TestConfigMergeRuleOrderOverridesAnalyzerScopedBaseline(t *testing.T) {
+	// Simulate merging gvisor's baseline config (an analyzer-scoped exclude)
+	// with a downstream project's config appended after it (a global
+	// include), the use case merge exists for: the downstream rule must win
+	// because it was declared later, regardless of it being global and the
+	// baseline rule being analyzer-scoped.
+	fs := token.NewFileSet()
+	d := diagnosticAt(fs, "pkg/sentry/fs/file.go")
+	unusedresult := &analysis.Analyzer{Name: "unusedresult"}
+
+	baseline := &config{Rules: []rule{{ID: "no-unusedresult", Analyzer: "unusedresult", Action: actionExclude}}}
+	downstream := &config{Rules: []rule{{ID: "reinclude-all", Action: actionInclude}}}
+	cm := baseline.merge(downstream).compile()
+
+	if !cm.ShouldReportFor(unusedresult, d, fs) {
+		t.Errorf("expected the downstream global include, appended after the baseline exclude, to win")
+	}
+}
+
+func TestConfigCompilePathScopedRule(t *testing.T) {
+	fs := token.NewFileSet()
+	unusedresult := &analysis.Analyzer{Name: "unusedresult"}
+
+	c := &config{Rules: []rule{{ID: "no-sentry", Analyzer: "unusedresult", Paths: []string{"pkg/sentry/**"}}}}
+	cm := c.compile()
+
+	if cm.ShouldReportFor(unusedresult, diagnosticAt(fs, "pkg/sentry/fs/file.go"), fs) {
+		t.Errorf("expected pkg/sentry/fs/file.go to be excluded")
+	}
+	if !cm.ShouldReportFor(unusedresult, diagnosticAt(fs, "pkg/tcpip/network/ipv6/icmp.go"), fs) {
+		t.Errorf("expected pkg/tcpip/network/ipv6/icmp.go to be unaffected")
+	}
+}
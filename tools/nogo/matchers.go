@@ -27,19 +27,89 @@ type matcher interface {
 	ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool
 }
 
+// matchType classifies how a single pathExpr is compared against a
+// filename, so that the common cases avoid a full regexp evaluation.
+type matchType int
+
+const (
+	// exactMatch compares with strings.Contains: the pattern has no
+	// metacharacters (see pathExpr.match for why not ==).
+	exactMatch matchType = iota
+
+	// prefixMatch compares with strings.HasPrefix: the pattern ends with
+	// ".*" (or "/**"), with that suffix stripped from literal.
+	prefixMatch
+
+	// suffixMatch compares with strings.HasSuffix: the pattern begins with
+	// ".*", with that prefix stripped from literal.
+	suffixMatch
+
+	// regexpMatch falls back to the compiled regexp for anything else.
+	regexpMatch
+)
+
+// pathExpr is a single compiled path exclusion, classified at construction
+// time so that ShouldReport can avoid regexp evaluation in the common
+// cases.
+type pathExpr struct {
+	kind    matchType
+	literal string
+	re      *regexp.Regexp
+}
+
+// classify inspects a compiled pattern's source and picks the cheapest
+// comparator for it. For prefixMatch and suffixMatch only the trailing
+// ".*"/"**" or leading ".*" is stripped: the rest is compared literally, so
+// any regexp metacharacters left in it (e.g. the "." in ".*_test.go") match
+// only themselves.
+func classify(src string) pathExpr {
+	switch {
+	case strings.HasSuffix(src, ".*"):
+		return pathExpr{kind: prefixMatch, literal: strings.TrimSuffix(src, ".*")}
+	case strings.HasSuffix(src, "/**"):
+		return pathExpr{kind: prefixMatch, literal: strings.TrimSuffix(src, "**")}
+	case strings.HasPrefix(src, ".*"):
+		return pathExpr{kind: suffixMatch, literal: strings.TrimPrefix(src, ".*")}
+	case regexp.QuoteMeta(src) == src:
+		return pathExpr{kind: exactMatch, literal: src}
+	default:
+		return pathExpr{kind: regexpMatch, re: regexp.MustCompile(src)}
+	}
+}
+
+// match reports whether name matches this pathExpr.
+func (p pathExpr) match(name string) bool {
+	switch p.kind {
+	case exactMatch:
+		// Despite the name, this isn't a full-string equality check: the
+		// original regexp.MustCompile(literal).MatchString(searchPos) this
+		// replaces matches literal anywhere in searchPos (e.g. a directory
+		// exclude like "pkg/sentry/socket/hostinet" must still match
+		// "pkg/sentry/socket/hostinet/socket.go"), so preserve that
+		// substring semantics here.
+		return strings.Contains(name, p.literal)
+	case prefixMatch:
+		return strings.HasPrefix(name, p.literal)
+	case suffixMatch:
+		return strings.HasSuffix(name, p.literal)
+	default:
+		return p.re.MatchString(name)
+	}
+}
+
 // pathRegexps excludes explicit paths.
 type pathRegexps struct {
-	expr      []*regexp.Regexp
+	expr      []pathExpr
 	whitelist bool
 }
 
-// buildRegexps builds a list of regular expressions.
+// buildRegexps builds a list of classified path expressions.
 //
 // This will panic on error.
-func buildRegexps(prefix string, args ...string) []*regexp.Regexp {
-	result := make([]*regexp.Regexp, 0, len(args))
+func buildRegexps(prefix string, args ...string) []pathExpr {
+	result := make([]pathExpr, 0, len(args))
 	for _, arg := range args {
-		result = append(result, regexp.MustCompile(filepath.Join(prefix, arg)))
+		result = append(result, classify(filepath.Join(prefix, arg)))
 	}
 	return result
 }
@@ -53,7 +123,7 @@ func (p *pathRegexps) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) boo
 		// tell the build paths used for files.
 		searchPos := fullPos.Filename
 		for {
-			if path.MatchString(searchPos) {
+			if path.match(searchPos) {
 				return p.whitelist
 			}
 			slash := strings.IndexByte(searchPos, '/')
@@ -114,6 +184,13 @@ func (a *andMatcher) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool
 	return a.first.ShouldReport(d, fs) && a.second.ShouldReport(d, fs)
 }
 
+// ShouldReportFor implements analyzerAware.ShouldReportFor, propagating the
+// analyzer to both branches so a composite like
+// and(byAnalyzer("unusedresult", ...), or(...)) scopes correctly.
+func (a *andMatcher) ShouldReportFor(analyzer *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	return shouldReportFor(a.first, analyzer, d, fs) && shouldReportFor(a.second, analyzer, d, fs)
+}
+
 // and is a syntactic convension for andMatcher.
 func and(first matcher, second matcher) *andMatcher {
 	return &andMatcher{
@@ -147,3 +224,97 @@ func (neverMatcher) ShouldReport(analysis.Diagnostic, *token.FileSet) bool {
 func disableMatches() neverMatcher {
 	return neverMatcher{}
 }
+
+// orMatcher is a composite matcher that reports a diagnostic if either of
+// its two matchers would.
+type orMatcher struct {
+	first  matcher
+	second matcher
+}
+
+// ShouldReport implements matcher.ShouldReport.
+func (o *orMatcher) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool {
+	return o.first.ShouldReport(d, fs) || o.second.ShouldReport(d, fs)
+}
+
+// ShouldReportFor implements analyzerAware.ShouldReportFor.
+func (o *orMatcher) ShouldReportFor(analyzer *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	return shouldReportFor(o.first, analyzer, d, fs) || shouldReportFor(o.second, analyzer, d, fs)
+}
+
+// or is a syntactic convension for orMatcher, mirroring and.
+func or(first matcher, second matcher) *orMatcher {
+	return &orMatcher{
+		first:  first,
+		second: second,
+	}
+}
+
+// notMatcher inverts another matcher's verdict.
+type notMatcher struct {
+	inner matcher
+}
+
+// ShouldReport implements matcher.ShouldReport.
+func (n *notMatcher) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool {
+	return !n.inner.ShouldReport(d, fs)
+}
+
+// ShouldReportFor implements analyzerAware.ShouldReportFor, propagating the
+// analyzer to inner so not(byAnalyzer(...)) scopes to that analyzer instead
+// of inverting across every analyzer's diagnostics.
+func (n *notMatcher) ShouldReportFor(analyzer *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	return !shouldReportFor(n.inner, analyzer, d, fs)
+}
+
+// not is a syntactic convension for notMatcher.
+func not(inner matcher) *notMatcher {
+	return &notMatcher{inner: inner}
+}
+
+// analyzerAware is implemented by matchers that need to know which
+// analyzer raised a diagnostic (e.g. analyzerMatcher itself, or a
+// composite matcher built out of one). Matchers that don't implement it
+// are treated as analyzer-agnostic: their ordinary ShouldReport verdict
+// applies regardless of which analyzer is asking.
+type analyzerAware interface {
+	ShouldReportFor(a *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool
+}
+
+// shouldReportFor evaluates m for diagnostic d raised by analyzer a,
+// dispatching to ShouldReportFor when m is analyzerAware and falling back
+// to the analyzer-agnostic ShouldReport otherwise.
+func shouldReportFor(m matcher, a *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	if aa, ok := m.(analyzerAware); ok {
+		return aa.ShouldReportFor(a, d, fs)
+	}
+	return m.ShouldReport(d, fs)
+}
+
+// analyzerMatcher restricts another matcher's verdict to diagnostics
+// raised by a single named analyzer; for any other analyzer it reports
+// (i.e. defers the decision to other matchers it may be and-ed with).
+type analyzerMatcher struct {
+	name  string
+	inner matcher
+}
+
+// byAnalyzer scopes inner to only apply to diagnostics from the named
+// analyzer, e.g. and(byAnalyzer("unusedresult", ...), ...).
+func byAnalyzer(name string, inner matcher) *analyzerMatcher {
+	return &analyzerMatcher{name: name, inner: inner}
+}
+
+// ShouldReport implements matcher.ShouldReport. Without an analysis.Analyzer
+// to compare against, this conservatively defers to inner.
+func (a *analyzerMatcher) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool {
+	return a.inner.ShouldReport(d, fs)
+}
+
+// ShouldReportFor implements analyzerAware.ShouldReportFor.
+func (a *analyzerMatcher) ShouldReportFor(analyzer *analysis.Analyzer, d analysis.Diagnostic, fs *token.FileSet) bool {
+	if analyzer.Name != a.name {
+		return true
+	}
+	return shouldReportFor(a.inner, analyzer, d, fs)
+}
@@ -0,0 +1,135 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"go/token"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+func diagnosticAt(fs *token.FileSet, filename string) analysis.Diagnostic {
+	f := fs.AddFile(filename, -1, 1)
+	return analysis.Diagnostic{Pos: f.Pos(0)}
+}
+
+func TestPathExprClassify(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		kind    matchType
+	}{
+		{"pkg/sentry/fs", exactMatch},
+		{"pkg/sentry/.*", prefixMatch},
+		{"pkg/sentry/**", prefixMatch},
+		{".*_test.go", suffixMatch},
+		{"pkg/.*/fs", regexpMatch},
+	} {
+		if got := classify(test.pattern).kind; got != test.kind {
+			t.Errorf("classify(%q).kind = %v, want %v", test.pattern, got, test.kind)
+		}
+	}
+}
+
+func TestPathRegexpsShouldReport(t *testing.T) {
+	fs := token.NewFileSet()
+	p := &pathRegexps{
+		expr:      buildRegexps("", "pkg/sentry/.*"),
+		whitelist: false,
+	}
+
+	if p.ShouldReport(diagnosticAt(fs, "pkg/sentry/fs/file.go"), fs) {
+		t.Errorf("expected pkg/sentry/fs/file.go to be excluded")
+	}
+	if !p.ShouldReport(diagnosticAt(fs, "pkg/tcpip/network/ipv6/icmp.go"), fs) {
+		t.Errorf("expected pkg/tcpip/network/ipv6/icmp.go to be reported")
+	}
+}
+
+func TestPathRegexpsShouldReportExactMatchIsSubstring(t *testing.T) {
+	// A bare literal (metacharacter-free) pattern must still exclude every
+	// file under that directory, not just a file whose path equals the
+	// pattern exactly, matching the regexp.MustCompile(literal).MatchString
+	// substring semantics this classification is meant to shortcut.
+	fs := token.NewFileSet()
+	p := &pathRegexps{
+		expr:      buildRegexps("", "pkg/sentry/socket/hostinet"),
+		whitelist: false,
+	}
+
+	if p.ShouldReport(diagnosticAt(fs, "pkg/sentry/socket/hostinet/socket.go"), fs) {
+		t.Errorf("expected pkg/sentry/socket/hostinet/socket.go to be excluded")
+	}
+	if !p.ShouldReport(diagnosticAt(fs, "pkg/sentry/socket/unix/socket.go"), fs) {
+		t.Errorf("expected pkg/sentry/socket/unix/socket.go to be reported")
+	}
+}
+
+func TestOrNotAnalyzerMatchers(t *testing.T) {
+	fs := token.NewFileSet()
+	d := diagnosticAt(fs, "foo/bar.go")
+
+	if (or(disableMatches(), disableMatches())).ShouldReport(d, fs) {
+		t.Errorf("or(never, never) should not report")
+	}
+	if !(or(alwaysMatches(), disableMatches())).ShouldReport(d, fs) {
+		t.Errorf("or(always, never) should report")
+	}
+	if not(alwaysMatches()).ShouldReport(d, fs) {
+		t.Errorf("not(always) should not report")
+	}
+
+	unusedresult := &analysis.Analyzer{Name: "unusedresult"}
+	nilness := &analysis.Analyzer{Name: "nilness"}
+	m := byAnalyzer("unusedresult", disableMatches())
+
+	if m.ShouldReportFor(unusedresult, d, fs) {
+		t.Errorf("expected unusedresult diagnostics to be excluded")
+	}
+	if !m.ShouldReportFor(nilness, d, fs) {
+		t.Errorf("expected nilness diagnostics to be unaffected")
+	}
+
+	// not() must propagate the analyzer to an analyzerAware inner matcher
+	// (here m, which excludes unusedresult but leaves nilness alone) rather
+	// than falling back to the analyzer-blind ShouldReport, which would
+	// apply m's verdict to every analyzer uniformly.
+	notM := not(m)
+	if !notM.ShouldReportFor(unusedresult, d, fs) {
+		t.Errorf("expected not(byAnalyzer(unusedresult, disabled)) to report unusedresult diagnostics")
+	}
+	if notM.ShouldReportFor(nilness, d, fs) {
+		t.Errorf("expected not(byAnalyzer(unusedresult, disabled)) to exclude nilness diagnostics")
+	}
+}
+
+func BenchmarkPathRegexpsShouldReport(b *testing.B) {
+	fs := token.NewFileSet()
+	p := &pathRegexps{
+		expr: buildRegexps("",
+			"pkg/sentry/.*",
+			"pkg/tcpip/.*",
+			".*_test.go",
+			"tools/nogo/matchers.go",
+		),
+		whitelist: false,
+	}
+	d := diagnosticAt(fs, "pkg/tcpip/network/ipv6/icmp.go")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.ShouldReport(d, fs)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// packagePattern is a single compiled Go import path pattern, using the
+// same pattern language as `go build`/`go vet` (see
+// golang.org/x/tools/internal/pkgpattern): a literal import path, a
+// "foo/..." wildcard that matches foo and everything below it, and the
+// meta-patterns "all", "std" and "cmd".
+type packagePattern struct {
+	// meta is set for the "std"/"cmd"/"all" meta-patterns, which are not
+	// expressible as a single anchored regexp against an import path.
+	meta string
+
+	// re matches the import path for all other patterns. It is the
+	// anchored regexp equivalent of the pattern, built the same way
+	// pkgpattern.MatchPattern does: metacharacters are escaped, and every
+	// "..." is expanded to ".*", with a "/..." suffix also matching the
+	// empty tail so that "foo/..." matches "foo" itself.
+	re *regexp.Regexp
+}
+
+// newPackagePattern compiles a single Go package pattern.
+func newPackagePattern(pattern string) packagePattern {
+	switch pattern {
+	case "all", "std", "cmd":
+		return packagePattern{meta: pattern}
+	}
+
+	// A leading "./" names a filesystem-relative tree; for our purposes
+	// (matching against slash-separated import paths) it behaves the same
+	// as the path with the prefix stripped.
+	pattern = strings.TrimPrefix(pattern, "./")
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); {
+		if strings.HasPrefix(pattern[i:], "...") {
+			b.WriteString(".*")
+			i += 3
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		i++
+	}
+
+	body := b.String()
+	// A trailing "/..." also matches the empty tail, so "foo/..." matches
+	// "foo" itself: fold the separator into the optional part instead of
+	// leaving it as a required literal "/" ahead of the wildcard.
+	if strings.HasSuffix(body, `/.*`) {
+		body = strings.TrimSuffix(body, `/.*`) + `(?:/.*)?`
+	}
+
+	return packagePattern{re: regexp.MustCompile("^" + body + "$")}
+}
+
+// match reports whether importPath is selected by this pattern.
+func (p packagePattern) match(importPath string) bool {
+	switch p.meta {
+	case "std", "cmd":
+		// Treat "no dot in the first path element" as shorthand for "lives
+		// under GOROOT/src" without needing to consult the build list;
+		// within that, "cmd" selects the cmd tree and "std" everything else.
+		first := importPath
+		if slash := strings.IndexByte(importPath, '/'); slash >= 0 {
+			first = importPath[:slash]
+		}
+		if strings.Contains(first, ".") {
+			return false
+		}
+		return (first == "cmd") == (p.meta == "cmd")
+	case "all":
+		return true
+	}
+	return p.re.MatchString(importPath)
+}
+
+// packagePatterns is a matcher implementation that selects diagnostics by
+// the Go import path of the package they were reported in, using the same
+// pattern language as `go build`'s package arguments (see
+// golang.org/x/tools/internal/pkgpattern.MatchPattern).
+type packagePatterns struct {
+	moduleRoot string
+	modulePath string
+	patterns   []packagePattern
+}
+
+// newPackagePatterns builds a packagePatterns matcher. moduleRoot and
+// modulePath are used to resolve a diagnostic's filesystem path back to an
+// import path: moduleRoot is the absolute path of the module's root
+// directory on disk and modulePath is its Go module path.
+func newPackagePatterns(moduleRoot, modulePath string, patterns ...string) *packagePatterns {
+	p := &packagePatterns{
+		moduleRoot: moduleRoot,
+		modulePath: modulePath,
+		patterns:   make([]packagePattern, 0, len(patterns)),
+	}
+	for _, pattern := range patterns {
+		p.patterns = append(p.patterns, newPackagePattern(pattern))
+	}
+	return p
+}
+
+// importPath resolves the directory containing filename to a Go import
+// path relative to the module root.
+func (p *packagePatterns) importPath(filename string) (string, bool) {
+	rel, err := filepath.Rel(p.moduleRoot, filepath.Dir(filename))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	if rel == "." {
+		return p.modulePath, true
+	}
+	return p.modulePath + "/" + filepath.ToSlash(rel), true
+}
+
+// ShouldReport implements matcher.ShouldReport.
+func (p *packagePatterns) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool {
+	importPath, ok := p.importPath(fs.Position(d.Pos).Filename)
+	if !ok {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.match(importPath) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import "testing"
+
+func TestPackagePatternMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern    string
+		importPath string
+		want       bool
+	}{
+		{"foo/bar", "foo/bar", true},
+		{"foo/bar", "foo/baz", false},
+		{"foo/...", "foo", true},
+		{"foo/...", "foo/bar", true},
+		{"foo/...", "foo/bar/baz", true},
+		{"foo/...", "foobar", false},
+		{"foo/.../bar", "foo/x/bar", true},
+		{"foo/.../bar", "foo/bar", false},
+		{"...", "anything/at/all", true},
+		{"std", "fmt", true},
+		{"std", "gvisor.dev/gvisor/pkg/tcpip", false},
+		{"all", "gvisor.dev/gvisor/pkg/tcpip", true},
+	} {
+		if got := newPackagePattern(test.pattern).match(test.importPath); got != test.want {
+			t.Errorf("newPackagePattern(%q).match(%q) = %v, want %v", test.pattern, test.importPath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// pathPattern is a single gitignore/dockerignore-style pattern, as
+// implemented by moby's patternmatcher: a shell glob over path segments
+// that additionally understands "**" to mean "zero or more path segments",
+// plus a leading "!" that negates (re-includes) a filename matched by an
+// earlier pattern.
+type pathPattern struct {
+	// negate is true for a "!"-prefixed pattern: a filename it matches is
+	// re-included rather than excluded.
+	negate bool
+
+	// cleaned is the pattern with the leading "!" (if any) stripped and the
+	// path cleaned, ready to be matched against with filepath.Match-style
+	// semantics (after "**" expansion).
+	cleaned string
+}
+
+// newPathPattern parses a single dockerignore-style pattern line.
+func newPathPattern(pattern string) pathPattern {
+	negate := false
+	if strings.HasPrefix(pattern, "!") {
+		negate = true
+		pattern = pattern[1:]
+	}
+	return pathPattern{
+		negate:  negate,
+		cleaned: filepath.Clean(pattern),
+	}
+}
+
+// match reports whether name (a clean, slash-separated relative path)
+// matches this pattern, per the moby patternmatcher algorithm: the pattern
+// is matched one path segment at a time, with "**" allowed to consume any
+// number of segments (including zero).
+func (p pathPattern) match(name string) bool {
+	matched, err := matchPatternSegments(strings.Split(p.cleaned, "/"), strings.Split(name, "/"))
+	if err != nil {
+		return false
+	}
+	return matched
+}
+
+// matchPatternSegments implements "**"-aware segment matching: a literal
+// segment is matched with filepath.Match (supporting "*", "?" and character
+// classes), and "**" matches any number of segments, including none.
+func matchPatternSegments(pattern, name []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(name) == 0, nil
+	}
+
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return true, nil
+		}
+		for i := 0; i <= len(name); i++ {
+			if matched, err := matchPatternSegments(pattern[1:], name[i:]); err != nil {
+				return false, err
+			} else if matched {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if len(name) == 0 {
+		return false, nil
+	}
+
+	matched, err := filepath.Match(pattern[0], name[0])
+	if err != nil {
+		return false, err
+	}
+	if !matched {
+		return false, nil
+	}
+	return matchPatternSegments(pattern[1:], name[1:])
+}
+
+// pathPatterns is a matcher implementation that evaluates a dockerignore-
+// style pattern list against a diagnostic's file path. Patterns are
+// evaluated in order, with later matches overriding earlier ones, so rules
+// like `pkg/sentry/**` followed by `!pkg/sentry/vfs/*_test.go` behave as
+// expected.
+type pathPatterns struct {
+	patterns []pathPattern
+}
+
+// newPathPatterns builds a pathPatterns matcher from a list of
+// dockerignore-style pattern strings.
+func newPathPatterns(patterns ...string) *pathPatterns {
+	p := &pathPatterns{patterns: make([]pathPattern, 0, len(patterns))}
+	for _, pattern := range patterns {
+		p.patterns = append(p.patterns, newPathPattern(pattern))
+	}
+	return p
+}
+
+// ShouldReport implements matcher.ShouldReport. A diagnostic is excluded if
+// the last pattern to match its filename is not a negated ("!") pattern.
+func (p *pathPatterns) ShouldReport(d analysis.Diagnostic, fs *token.FileSet) bool {
+	name := filepath.Clean(fs.Position(d.Pos).Filename)
+
+	excluded := false
+	for _, pattern := range p.patterns {
+		if pattern.match(name) {
+			excluded = !pattern.negate
+		}
+	}
+	return !excluded
+}
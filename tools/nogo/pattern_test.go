@@ -0,0 +1,68 @@
+// Copyright 2019 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nogo
+
+import (
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestMatchPatternSegments(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"pkg/sentry/fs", "pkg/sentry/fs", true},
+		{"pkg/sentry/fs", "pkg/sentry/fs2", false},
+		{"pkg/sentry/*", "pkg/sentry/fs", true},
+		{"pkg/sentry/*", "pkg/sentry/fs/file.go", false},
+		{"pkg/sentry/**", "pkg/sentry", true},
+		{"pkg/sentry/**", "pkg/sentry/fs/file.go", true},
+		{"**/file.go", "pkg/sentry/fs/file.go", true},
+		{"**/file.go", "pkg/sentry/fs/other.go", false},
+	} {
+		got, err := matchPatternSegments(
+			strings.Split(test.pattern, "/"),
+			strings.Split(test.name, "/"),
+		)
+		if err != nil {
+			t.Errorf("matchPatternSegments(%q, %q) returned error: %v", test.pattern, test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("matchPatternSegments(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestPathPatternsShouldReportLastMatchWins(t *testing.T) {
+	fs := token.NewFileSet()
+	p := &pathPatterns{patterns: []pathPattern{
+		newPathPattern("pkg/sentry/**"),
+		newPathPattern("!pkg/sentry/vfs/*_test.go"),
+	}}
+
+	if p.ShouldReport(diagnosticAt(fs, "pkg/sentry/fs/file.go"), fs) {
+		t.Errorf("expected pkg/sentry/fs/file.go to be excluded")
+	}
+	if !p.ShouldReport(diagnosticAt(fs, "pkg/sentry/vfs/file_test.go"), fs) {
+		t.Errorf("expected the negated pattern to re-include pkg/sentry/vfs/file_test.go")
+	}
+	if !p.ShouldReport(diagnosticAt(fs, "pkg/tcpip/network/ipv6/icmp.go"), fs) {
+		t.Errorf("expected pkg/tcpip/network/ipv6/icmp.go to be unaffected")
+	}
+}